@@ -0,0 +1,138 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"net/http"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/Percona-Lab/PromHouse/storages"
+)
+
+// maxSeriesPerFrame bounds how many ChunkedSeries accumulate before a ChunkedReadResponse
+// frame is flushed to the client, keeping a single frame's memory use bounded regardless of
+// how many series a query matches.
+const maxSeriesPerFrame = 64
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// chunkedWriter writes length-delimited, checksummed protobuf frames, matching the wire format
+// Prometheus' own remote read client expects for STREAMED_XOR_CHUNKS responses: a uvarint
+// message length, a big-endian CRC32C checksum of the message, then the message itself.
+type chunkedWriter struct {
+	rw http.ResponseWriter
+	f  http.Flusher
+}
+
+func newChunkedWriter(rw http.ResponseWriter) *chunkedWriter {
+	f, _ := rw.(http.Flusher)
+	return &chunkedWriter{rw: rw, f: f}
+}
+
+func (w *chunkedWriter) WriteFrame(msg proto.Message) error {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	var sizeBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(sizeBuf[:], uint64(len(b)))
+	if _, err := w.rw.Write(sizeBuf[:n]); err != nil {
+		return err
+	}
+
+	var crcBuf [4]byte
+	binary.BigEndian.PutUint32(crcBuf[:], crc32.Checksum(b, castagnoliTable))
+	if _, err := w.rw.Write(crcBuf[:]); err != nil {
+		return err
+	}
+
+	if _, err := w.rw.Write(b); err != nil {
+		return err
+	}
+	if w.f != nil {
+		w.f.Flush()
+	}
+	return nil
+}
+
+// readStreamed services a Read request whose client asked for STREAMED_XOR_CHUNKS: instead of
+// building the whole prompb.ReadResponse in memory, it consumes storages.SeriesChunk values off
+// Storage.ReadStream as they arrive and flushes them to the client as ChunkedReadResponse
+// frames, so memory use stays proportional to a single frame rather than the full result set.
+func (p *PromAPI) readStreamed(rw http.ResponseWriter, ctx context.Context, queries []storages.Query) error {
+	ch, err := p.Storage.ReadStream(ctx, queries)
+	if err != nil {
+		return err
+	}
+
+	rw.Header().Set("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+	cw := newChunkedWriter(rw)
+
+	var series int
+	var frame *prompb.ChunkedReadResponse
+	frameQuery := -1
+	for sc := range ch {
+		if sc.Err != nil {
+			return sc.Err
+		}
+
+		// A frame's QueryIndex applies to every series it carries, so a query boundary -- not
+		// just maxSeriesPerFrame -- always starts a new frame; otherwise series from different
+		// queries sharing a frame would be mislabeled as all belonging to frameQuery.
+		if frame == nil || sc.QueryIndex != frameQuery || len(frame.ChunkedSeries) >= maxSeriesPerFrame {
+			if frame != nil {
+				if err := cw.WriteFrame(frame); err != nil {
+					return err
+				}
+			}
+			frame = &prompb.ChunkedReadResponse{QueryIndex: int64(sc.QueryIndex)}
+			frameQuery = sc.QueryIndex
+		}
+
+		cs := &prompb.ChunkedSeries{
+			Labels: make([]prompb.Label, 0, len(sc.Labels)),
+			Chunks: make([]prompb.Chunk, len(sc.Chunks)),
+		}
+		for n, v := range sc.Labels {
+			cs.Labels = append(cs.Labels, prompb.Label{Name: string(n), Value: string(v)})
+		}
+		for i, c := range sc.Chunks {
+			cs.Chunks[i] = prompb.Chunk{
+				MinTimeMs: int64(c.MinTime),
+				MaxTimeMs: int64(c.MaxTime),
+				Type:      prompb.Chunk_XOR,
+				Data:      c.Data,
+			}
+		}
+		frame.ChunkedSeries = append(frame.ChunkedSeries, cs)
+		series++
+	}
+	if frame != nil && len(frame.ChunkedSeries) > 0 {
+		if err := cw.WriteFrame(frame); err != nil {
+			return err
+		}
+	}
+
+	p.Logger.Infof("Streamed response: %d time series.", series)
+	return nil
+}
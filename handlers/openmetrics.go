@@ -0,0 +1,171 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+
+	"github.com/Percona-Lab/PromHouse/storages"
+	"github.com/Percona-Lab/PromHouse/wal"
+)
+
+// openMetricsMediaType is the Content-Type used by the OpenMetrics exposition format. It is
+// distinct from the legacy Prometheus text format's "text/plain", and expfmt.ResponseFormat in
+// the version of expfmt this repo vendors doesn't recognize it -- it falls through to
+// FmtUnknown. requestFormat checks for it explicitly and returns expfmt.FmtOpenMetrics. Note that
+// expfmt.NewDecoder has no separate OpenMetrics parser: it decodes every format other than
+// delimited protobuf with the text-format parser, so an OpenMetrics body is parsed as text format
+// either way, and OpenMetrics-only syntax such as exemplars is not supported.
+const openMetricsMediaType = "application/openmetrics-text"
+
+// requestFormat picks the expfmt.Format to decode req with, based on its Content-Type.
+func requestFormat(h http.Header) expfmt.Format {
+	if mediatype, _, err := mime.ParseMediaType(h.Get("Content-Type")); err == nil && mediatype == openMetricsMediaType {
+		return expfmt.FmtOpenMetrics
+	}
+	return expfmt.ResponseFormat(h)
+}
+
+// WriteText accepts metrics in the Prometheus text exposition format or OpenMetrics format
+// (Content-Type "text/plain; version=0.0.4" or "application/openmetrics-text") and writes
+// them to storage exactly like Write does for snappy/protobuf remote-write requests, going
+// through p.WAL for durable buffering and back-pressure when one is configured. It lets tools
+// that only know how to emit a scrape payload (one-shot batch jobs, metricbeat-style agents)
+// push data without having to speak the remote-write protocol.
+func (p *PromAPI) WriteText(rw http.ResponseWriter, req *http.Request) error {
+	tenantID, err := p.Tenants.tenantID(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	decoder := expfmt.NewDecoder(req.Body, requestFormat(req.Header))
+
+	now := model.Now()
+	var data model.Matrix
+	for {
+		var mf dto.MetricFamily
+		if err := decoder.Decode(&mf); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		ss, err := sampleStreamsFromMetricFamily(&mf, now)
+		if err != nil {
+			return err
+		}
+		data = append(data, ss...)
+	}
+
+	var samples int
+	for _, ss := range data {
+		ss.Metric[storages.TenantLabel] = model.LabelValue(tenantID)
+		samples += len(ss.Values)
+	}
+
+	p.Logger.Infof("Writing %d time series, %d samples for tenant %q (exposition format).", len(data), samples, tenantID)
+	p.Logger.Debugf("Writing data:\n%s", data)
+	ctx := storages.NewContext(req.Context(), tenantID)
+
+	if p.WAL != nil {
+		if err := p.WAL.Append(ctx, data); err != nil {
+			if err == wal.ErrQueueFull {
+				http.Error(rw, err.Error(), http.StatusTooManyRequests)
+				return nil
+			}
+			return err
+		}
+		return nil
+	}
+	return p.Storage.Write(ctx, data)
+}
+
+// sampleStreamsFromMetricFamily expands a single MetricFamily into one SampleStream per
+// series it describes: a counter or gauge is a single series, while a histogram or summary
+// expand into their constituent `_bucket`/`_sum`/`_count` and quantile series, mirroring how
+// Prometheus itself stores them once scraped.
+func sampleStreamsFromMetricFamily(mf *dto.MetricFamily, defaultTimestamp model.Time) ([]*model.SampleStream, error) {
+	name := mf.GetName()
+	var res []*model.SampleStream
+
+	newStream := func(metricName string, labels []*dto.LabelPair, extra model.LabelSet, value float64, m *dto.Metric) *model.SampleStream {
+		metric := make(model.Metric, len(labels)+len(extra)+1)
+		metric[model.MetricNameLabel] = model.LabelValue(metricName)
+		for _, lp := range labels {
+			metric[model.LabelName(lp.GetName())] = model.LabelValue(lp.GetValue())
+		}
+		for n, v := range extra {
+			metric[n] = v
+		}
+		ts := defaultTimestamp
+		if m.TimestampMs != nil {
+			ts = model.Time(m.GetTimestampMs())
+		}
+		return &model.SampleStream{
+			Metric: metric,
+			Values: []model.SamplePair{{Timestamp: ts, Value: model.SampleValue(value)}},
+		}
+	}
+
+	for _, m := range mf.GetMetric() {
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			res = append(res, newStream(name, m.GetLabel(), nil, m.GetCounter().GetValue(), m))
+
+		case dto.MetricType_GAUGE:
+			res = append(res, newStream(name, m.GetLabel(), nil, m.GetGauge().GetValue(), m))
+
+		case dto.MetricType_UNTYPED:
+			res = append(res, newStream(name, m.GetLabel(), nil, m.GetUntyped().GetValue(), m))
+
+		case dto.MetricType_HISTOGRAM:
+			h := m.GetHistogram()
+			for _, b := range h.GetBucket() {
+				extra := model.LabelSet{"le": model.LabelValue(formatFloat(b.GetUpperBound()))}
+				res = append(res, newStream(name+"_bucket", m.GetLabel(), extra, float64(b.GetCumulativeCount()), m))
+			}
+			res = append(res, newStream(name+"_sum", m.GetLabel(), nil, h.GetSampleSum(), m))
+			res = append(res, newStream(name+"_count", m.GetLabel(), nil, float64(h.GetSampleCount()), m))
+
+		case dto.MetricType_SUMMARY:
+			s := m.GetSummary()
+			for _, q := range s.GetQuantile() {
+				extra := model.LabelSet{"quantile": model.LabelValue(formatFloat(q.GetQuantile()))}
+				res = append(res, newStream(name, m.GetLabel(), extra, q.GetValue(), m))
+			}
+			res = append(res, newStream(name+"_sum", m.GetLabel(), nil, s.GetSampleSum(), m))
+			res = append(res, newStream(name+"_count", m.GetLabel(), nil, float64(s.GetSampleCount()), m))
+
+		default:
+			return nil, fmt.Errorf("unsupported metric type %s for %q", mf.GetType(), name)
+		}
+	}
+
+	return res, nil
+}
+
+func formatFloat(f float64) string {
+	return fmt.Sprintf("%g", f)
+}
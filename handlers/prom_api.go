@@ -17,85 +17,66 @@
 package handlers
 
 import (
-	"fmt"
-	"io/ioutil"
 	"net/http"
 
-	"github.com/gogo/protobuf/proto"
-	"github.com/golang/snappy"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/prompb"
 	"github.com/sirupsen/logrus"
 
+	"github.com/Percona-Lab/PromHouse/remote/codec"
 	"github.com/Percona-Lab/PromHouse/storages"
+	"github.com/Percona-Lab/PromHouse/wal"
 )
 
 type PromAPI struct {
 	Storage storages.Storage
 	Logger  *logrus.Entry
+	Tenants TenantConfig
+
+	// WAL, if set, buffers writes durably ahead of Storage; see package wal. If nil, Write
+	// calls Storage.Write directly.
+	WAL *wal.WAL
 }
 
-func readPB(req *http.Request, pb proto.Message) error {
-	compressed, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		return err
-	}
-	b, err := snappy.Decode(nil, compressed)
+func (p *PromAPI) Read(rw http.ResponseWriter, req *http.Request) error {
+	tenantID, err := p.Tenants.tenantID(req)
 	if err != nil {
-		return err
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return nil
 	}
-	return proto.Unmarshal(b, pb)
-}
 
-func (p *PromAPI) Read(rw http.ResponseWriter, req *http.Request) error {
-	var request prompb.ReadRequest
-	if err := readPB(req, &request); err != nil {
+	request, err := codec.DecodeReadRequest(req)
+	if err != nil {
 		return err
 	}
 
-	// convert to query
+	// convert to queries, forcing tenant isolation: every query must match this tenant's series
 	queries := make([]storages.Query, len(request.Queries))
 	for i, rq := range request.Queries {
-		empty := true
-		q := storages.Query{
-			Start:    model.Time(rq.StartTimestampMs),
-			End:      model.Time(rq.EndTimestampMs),
-			Matchers: make([]storages.Matcher, len(rq.Matchers)),
-		}
-		for j, m := range rq.Matchers {
-			var t storages.MatchType
-			switch m.Type {
-			case prompb.LabelMatcher_EQ:
-				t = storages.MatchEqual
-			case prompb.LabelMatcher_NEQ:
-				t = storages.MatchNotEqual
-			case prompb.LabelMatcher_RE:
-				t = storages.MatchRegexp
-			case prompb.LabelMatcher_NRE:
-				t = storages.MatchNotRegexp
-			default:
-				return fmt.Errorf("unexpected matcher %d", m.Type)
-			}
-
-			q.Matchers[j] = storages.Matcher{
-				Type:  t,
-				Name:  model.LabelName(m.Name),
-				Value: m.Value,
-			}
-			if m.Value != "" {
-				empty = false
-			}
-		}
-
-		if empty {
-			p.Logger.Panicf("expectation failed: at least one matcher should have non-empty label value")
+		q, err := codec.FromQuery(rq)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return nil
 		}
+		q.Matchers = append(q.Matchers, storages.Matcher{
+			Type:  storages.MatchEqual,
+			Name:  storages.TenantLabel,
+			Value: tenantID,
+		})
 		queries[i] = q
 	}
 
 	// read from storage
 	p.Logger.Infof("Queries: %s", queries)
-	data, err := p.Storage.Read(req.Context(), queries)
+	ctx := storages.NewContext(req.Context(), tenantID)
+
+	for _, rt := range request.AcceptedResponseTypes {
+		if rt == prompb.ReadRequest_STREAMED_XOR_CHUNKS {
+			return p.readStreamed(rw, ctx, queries)
+		}
+	}
+
+	data, err := p.Storage.Read(ctx, queries)
 	if err != nil {
 		return err
 	}
@@ -107,87 +88,54 @@ func (p *PromAPI) Read(rw http.ResponseWriter, req *http.Request) error {
 	}
 	var series, samples int
 	for i, m := range data {
-		qr := &prompb.QueryResult{
-			Timeseries: make([]*prompb.TimeSeries, len(m)),
+		response.Results[i] = &prompb.QueryResult{Timeseries: codec.TimeSeriesFromMatrix(m)}
+		series += len(m)
+		for _, ss := range m {
+			samples += len(ss.Values)
 		}
-		for j, ss := range m {
-			ts := &prompb.TimeSeries{
-				Labels:  make([]*prompb.Label, 0, len(ss.Metric)),
-				Samples: make([]*prompb.Sample, len(ss.Values)),
-			}
-			for n, v := range ss.Metric {
-				ts.Labels = append(ts.Labels, &prompb.Label{
-					Name:  string(n),
-					Value: string(v),
-				})
-			}
-			for k, sp := range ss.Values {
-				ts.Samples[k] = &prompb.Sample{
-					Timestamp: int64(sp.Timestamp),
-					Value:     float64(sp.Value),
-				}
-				samples++
-			}
-			qr.Timeseries[j] = ts
-			series++
-		}
-		response.Results[i] = qr
 	}
 	p.Logger.Infof("Response: %d matrixes, %d time series, %d samples.", len(data), series, samples)
 
-	// marshal, encode and write response
-	b, err := proto.Marshal(&response)
+	return codec.EncodeReadResponse(&response, rw)
+}
+
+func (p *PromAPI) Write(rw http.ResponseWriter, req *http.Request) error {
+	tenantID, err := p.Tenants.tenantID(req)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	request, err := codec.DecodeWriteRequest(req)
 	if err != nil {
 		return err
 	}
-	rw.Header().Set("Content-Type", "application/x-protobuf")
-	rw.Header().Set("Content-Encoding", "snappy")
-	compressed := snappy.Encode(nil, b)
-	_, err = rw.Write(compressed)
-	return err
-}
 
-func (p *PromAPI) Write(rw http.ResponseWriter, req *http.Request) error {
-	var request prompb.WriteRequest
-	if err := readPB(req, &request); err != nil {
+	data, err := codec.MatrixFromTimeSeries(request.Timeseries)
+	if err != nil {
 		return err
 	}
 
-	// convert to matrix
 	var samples int
-	data := make(model.Matrix, len(request.Timeseries))
-	for i, ts := range request.Timeseries {
-		ss := &model.SampleStream{
-			Metric: make(model.Metric, len(ts.Labels)),
-			Values: make([]model.SamplePair, len(ts.Samples)),
-		}
-		for _, l := range ts.Labels {
-			n := model.LabelName(l.Name)
-			v := model.LabelValue(l.Value)
-			if !n.IsValid() {
-				return fmt.Errorf("invalid label name %q", n)
-			}
-			if n == model.MetricNameLabel {
-				if !model.IsValidMetricName(v) {
-					return fmt.Errorf("invalid metric name %q", v)
-				}
-			} else if !v.IsValid() {
-				return fmt.Errorf("invalid value %q for label %s", v, n)
-			}
-			ss.Metric[n] = v
-		}
-		for j, s := range ts.Samples {
-			ss.Values[j] = model.SamplePair{
-				Timestamp: model.Time(s.Timestamp),
-				Value:     model.SampleValue(s.Value),
-			}
-			samples++
-		}
-		data[i] = ss
+	for _, ss := range data {
+		ss.Metric[storages.TenantLabel] = model.LabelValue(tenantID)
+		samples += len(ss.Values)
 	}
 
 	// write to storage
-	p.Logger.Infof("Writing %d time series, %d samples.", len(data), samples)
+	p.Logger.Infof("Writing %d time series, %d samples for tenant %q.", len(data), samples, tenantID)
 	p.Logger.Debugf("Writing data:\n%s", data)
-	return p.Storage.Write(req.Context(), data)
+	ctx := storages.NewContext(req.Context(), tenantID)
+
+	if p.WAL != nil {
+		if err := p.WAL.Append(ctx, data); err != nil {
+			if err == wal.ErrQueueFull {
+				http.Error(rw, err.Error(), http.StatusTooManyRequests)
+				return nil
+			}
+			return err
+		}
+		return nil
+	}
+	return p.Storage.Write(ctx, data)
 }
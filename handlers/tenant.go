@@ -0,0 +1,73 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// TenantConfig configures how PromAPI resolves the tenant a request belongs to.
+// It is modelled after Thanos/Cortex's tenant header convention so PromHouse can sit
+// behind the same multi-tenant remote read/write setups.
+type TenantConfig struct {
+	// Header is the HTTP header carrying the tenant ID, e.g. "THANOS-TENANT" or "X-Scope-OrgID".
+	// If empty, tenant isolation is disabled and DefaultTenant is used for every request.
+	Header string
+
+	// DefaultTenant is used when Header is empty, or when a request doesn't carry Header
+	// and AllowedTenants permits the default tenant.
+	DefaultTenant string
+
+	// AllowedTenants, if non-empty, is the set of tenant IDs PromAPI will accept.
+	// An empty AllowedTenants means any non-empty tenant ID is accepted.
+	AllowedTenants []string
+}
+
+// allowed reports whether tenantID may be used given c's allow-list.
+func (c *TenantConfig) allowed(tenantID string) bool {
+	if len(c.AllowedTenants) == 0 {
+		return true
+	}
+	for _, t := range c.AllowedTenants {
+		if t == tenantID {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantID resolves the tenant ID for req, returning an error if it is missing, empty,
+// or not present in the configured allow-list. When c.Header is empty, DefaultTenant is
+// returned as is, without either check.
+func (c *TenantConfig) tenantID(req *http.Request) (string, error) {
+	if c.Header == "" {
+		return c.DefaultTenant, nil
+	}
+
+	tenantID := req.Header.Get(c.Header)
+	if tenantID == "" {
+		tenantID = c.DefaultTenant
+	}
+	if tenantID == "" {
+		return "", fmt.Errorf("missing tenant: header %q is required", c.Header)
+	}
+	if !c.allowed(tenantID) {
+		return "", fmt.Errorf("unknown tenant %q", tenantID)
+	}
+	return tenantID, nil
+}
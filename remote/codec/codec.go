@@ -0,0 +1,230 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package codec converts between Prometheus' remote read/write wire format (snappy-compressed
+// protobuf) and the types storages.Storage understands. It used to live inline in package
+// handlers; pulling it out lets other consumers -- a future Thanos StoreAPI gRPC server, a
+// replay tool, tests -- reuse the same conversions without depending on the HTTP layer.
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/Percona-Lab/PromHouse/storages"
+)
+
+// ErrEmptyMatchers is returned by FromLabelMatchers (and so FromQuery) when every matcher has
+// an empty value, which would otherwise match every series in storage.
+var ErrEmptyMatchers = errors.New("codec: at least one matcher must have a non-empty value")
+
+func readPB(req *http.Request, pb proto.Message) error {
+	compressed, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, pb)
+}
+
+// DecodeReadRequest reads and decodes a snappy-compressed, protobuf-encoded ReadRequest body.
+func DecodeReadRequest(req *http.Request) (*prompb.ReadRequest, error) {
+	var request prompb.ReadRequest
+	if err := readPB(req, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// DecodeWriteRequest reads and decodes a snappy-compressed, protobuf-encoded WriteRequest body.
+func DecodeWriteRequest(req *http.Request) (*prompb.WriteRequest, error) {
+	var request prompb.WriteRequest
+	if err := readPB(req, &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// EncodeReadResponse snappy-compresses response and writes it to rw, setting the headers
+// Prometheus' remote read client expects.
+func EncodeReadResponse(response *prompb.ReadResponse, rw http.ResponseWriter) error {
+	b, err := proto.Marshal(response)
+	if err != nil {
+		return err
+	}
+	rw.Header().Set("Content-Type", "application/x-protobuf")
+	rw.Header().Set("Content-Encoding", "snappy")
+	_, err = rw.Write(snappy.Encode(nil, b))
+	return err
+}
+
+// FromLabelMatchers converts remote read matchers into storages.Matcher, failing with
+// ErrEmptyMatchers if none of them have a non-empty value.
+func FromLabelMatchers(matchers []*prompb.LabelMatcher) ([]storages.Matcher, error) {
+	// +1 capacity: callers doing tenant isolation append one more matcher right after.
+	res := make([]storages.Matcher, len(matchers), len(matchers)+1)
+	empty := true
+	for i, m := range matchers {
+		var t storages.MatchType
+		switch m.Type {
+		case prompb.LabelMatcher_EQ:
+			t = storages.MatchEqual
+		case prompb.LabelMatcher_NEQ:
+			t = storages.MatchNotEqual
+		case prompb.LabelMatcher_RE:
+			t = storages.MatchRegexp
+		case prompb.LabelMatcher_NRE:
+			t = storages.MatchNotRegexp
+		default:
+			return nil, fmt.Errorf("codec: unexpected matcher type %d", m.Type)
+		}
+
+		res[i] = storages.Matcher{
+			Type:  t,
+			Name:  model.LabelName(m.Name),
+			Value: m.Value,
+		}
+		if m.Value != "" {
+			empty = false
+		}
+	}
+
+	if empty {
+		return nil, ErrEmptyMatchers
+	}
+	return res, nil
+}
+
+// ToLabelMatchers converts storages.Matcher back into the remote read/write wire format.
+func ToLabelMatchers(matchers []storages.Matcher) ([]*prompb.LabelMatcher, error) {
+	res := make([]*prompb.LabelMatcher, len(matchers))
+	for i, m := range matchers {
+		var t prompb.LabelMatcher_Type
+		switch m.Type {
+		case storages.MatchEqual:
+			t = prompb.LabelMatcher_EQ
+		case storages.MatchNotEqual:
+			t = prompb.LabelMatcher_NEQ
+		case storages.MatchRegexp:
+			t = prompb.LabelMatcher_RE
+		case storages.MatchNotRegexp:
+			t = prompb.LabelMatcher_NRE
+		default:
+			return nil, fmt.Errorf("codec: unexpected matcher type %d", m.Type)
+		}
+
+		res[i] = &prompb.LabelMatcher{
+			Type:  t,
+			Name:  string(m.Name),
+			Value: m.Value,
+		}
+	}
+	return res, nil
+}
+
+// FromQuery converts a single remote read Query into a storages.Query.
+func FromQuery(rq *prompb.Query) (storages.Query, error) {
+	matchers, err := FromLabelMatchers(rq.Matchers)
+	if err != nil {
+		return storages.Query{}, err
+	}
+	return storages.Query{
+		Start:    model.Time(rq.StartTimestampMs),
+		End:      model.Time(rq.EndTimestampMs),
+		Matchers: matchers,
+	}, nil
+}
+
+// ToQuery converts a storages.Query back into the remote read wire format.
+func ToQuery(q storages.Query) (*prompb.Query, error) {
+	matchers, err := ToLabelMatchers(q.Matchers)
+	if err != nil {
+		return nil, err
+	}
+	return &prompb.Query{
+		StartTimestampMs: int64(q.Start),
+		EndTimestampMs:   int64(q.End),
+		Matchers:         matchers,
+	}, nil
+}
+
+// MatrixFromTimeSeries converts remote write time series into a model.Matrix, validating every
+// label name and value along the way.
+func MatrixFromTimeSeries(series []*prompb.TimeSeries) (model.Matrix, error) {
+	data := make(model.Matrix, len(series))
+	for i, ts := range series {
+		ss := &model.SampleStream{
+			// +1: callers doing tenant isolation set one more label right after.
+			Metric: make(model.Metric, len(ts.Labels)+1),
+			Values: make([]model.SamplePair, len(ts.Samples)),
+		}
+		for _, l := range ts.Labels {
+			n := model.LabelName(l.Name)
+			v := model.LabelValue(l.Value)
+			if !n.IsValid() {
+				return nil, fmt.Errorf("codec: invalid label name %q", n)
+			}
+			if n == model.MetricNameLabel {
+				if !model.IsValidMetricName(v) {
+					return nil, fmt.Errorf("codec: invalid metric name %q", v)
+				}
+			} else if !v.IsValid() {
+				return nil, fmt.Errorf("codec: invalid value %q for label %s", v, n)
+			}
+			ss.Metric[n] = v
+		}
+		for j, s := range ts.Samples {
+			ss.Values[j] = model.SamplePair{
+				Timestamp: model.Time(s.Timestamp),
+				Value:     model.SampleValue(s.Value),
+			}
+		}
+		data[i] = ss
+	}
+	return data, nil
+}
+
+// TimeSeriesFromMatrix converts a model.Matrix into the remote read wire format.
+func TimeSeriesFromMatrix(m model.Matrix) []*prompb.TimeSeries {
+	res := make([]*prompb.TimeSeries, len(m))
+	for i, ss := range m {
+		ts := &prompb.TimeSeries{
+			Labels:  make([]*prompb.Label, 0, len(ss.Metric)),
+			Samples: make([]*prompb.Sample, len(ss.Values)),
+		}
+		for n, v := range ss.Metric {
+			ts.Labels = append(ts.Labels, &prompb.Label{Name: string(n), Value: string(v)})
+		}
+		for j, sp := range ss.Values {
+			ts.Samples[j] = &prompb.Sample{
+				Timestamp: int64(sp.Timestamp),
+				Value:     float64(sp.Value),
+			}
+		}
+		res[i] = ts
+	}
+	return res
+}
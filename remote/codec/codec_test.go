@@ -0,0 +1,135 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package codec
+
+import (
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Percona-Lab/PromHouse/storages"
+)
+
+func TestFromLabelMatchers(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		matchers []*prompb.LabelMatcher
+		expected []storages.Matcher
+		err      string
+	}{
+		{
+			name:     "Equal",
+			matchers: []*prompb.LabelMatcher{{Type: prompb.LabelMatcher_EQ, Name: "job", Value: "node"}},
+			expected: []storages.Matcher{{Type: storages.MatchEqual, Name: "job", Value: "node"}},
+		},
+		{
+			name:     "NotEqual",
+			matchers: []*prompb.LabelMatcher{{Type: prompb.LabelMatcher_NEQ, Name: "job", Value: "node"}},
+			expected: []storages.Matcher{{Type: storages.MatchNotEqual, Name: "job", Value: "node"}},
+		},
+		{
+			name:     "Regexp",
+			matchers: []*prompb.LabelMatcher{{Type: prompb.LabelMatcher_RE, Name: "job", Value: "no.*"}},
+			expected: []storages.Matcher{{Type: storages.MatchRegexp, Name: "job", Value: "no.*"}},
+		},
+		{
+			name:     "NotRegexp",
+			matchers: []*prompb.LabelMatcher{{Type: prompb.LabelMatcher_NRE, Name: "job", Value: "no.*"}},
+			expected: []storages.Matcher{{Type: storages.MatchNotRegexp, Name: "job", Value: "no.*"}},
+		},
+		{
+			name: "EmptyMatchers",
+			matchers: []*prompb.LabelMatcher{
+				{Type: prompb.LabelMatcher_EQ, Name: "job", Value: ""},
+				{Type: prompb.LabelMatcher_NEQ, Name: "instance", Value: ""},
+			},
+			err: "codec: at least one matcher must have a non-empty value",
+		},
+		{
+			name:     "UnexpectedType",
+			matchers: []*prompb.LabelMatcher{{Type: 42, Name: "job", Value: "node"}},
+			err:      "codec: unexpected matcher type 42",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := FromLabelMatchers(tc.matchers)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				assert.Nil(t, actual)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestFromLabelMatchersEmptyIsBadRequest(t *testing.T) {
+	_, err := FromLabelMatchers([]*prompb.LabelMatcher{{Type: prompb.LabelMatcher_EQ, Name: "job", Value: ""}})
+	require.Equal(t, ErrEmptyMatchers, err)
+}
+
+func TestMatrixFromTimeSeries(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		series []*prompb.TimeSeries
+		err    string
+	}{
+		{
+			name: "Valid",
+			series: []*prompb.TimeSeries{{
+				Labels:  []*prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+				Samples: []*prompb.Sample{{Timestamp: 1, Value: 1}},
+			}},
+		},
+		{
+			name: "InvalidLabelName",
+			series: []*prompb.TimeSeries{{
+				Labels: []*prompb.Label{{Name: "job-name", Value: "node"}},
+			}},
+			err: `codec: invalid label name "job-name"`,
+		},
+		{
+			name: "InvalidMetricName",
+			series: []*prompb.TimeSeries{{
+				Labels: []*prompb.Label{{Name: "__name__", Value: "123"}},
+			}},
+			err: `codec: invalid metric name "123"`,
+		},
+		{
+			name: "InvalidLabelValue",
+			series: []*prompb.TimeSeries{{
+				Labels: []*prompb.Label{{Name: "job", Value: string([]byte{0xff, 0xfe})}},
+			}},
+			err: "codec: invalid value \"\\xff\\xfe\" for label job",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := MatrixFromTimeSeries(tc.series)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				assert.Nil(t, data)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, data, 1)
+			assert.Equal(t, "up", string(data[0].Metric["__name__"]))
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package storages
+
+import (
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// MaxSamplesPerChunk bounds how many samples ChunksFromSamples packs into a single XOR chunk
+// before starting a new one, mirroring the target chunk size used by Prometheus' own TSDB.
+const MaxSamplesPerChunk = 120
+
+// ChunksFromSamples XOR-encodes values into one or more chunks via tsdb/chunkenc. It is
+// exported so that every Storage implementing ReadStream shares the same encoding instead of
+// each backend reimplementing chunkenc.Appender bookkeeping.
+func ChunksFromSamples(values []model.SamplePair) ([]Chunk, error) {
+	var chunks []Chunk
+	for len(values) > 0 {
+		n := len(values)
+		if n > MaxSamplesPerChunk {
+			n = MaxSamplesPerChunk
+		}
+		batch := values[:n]
+		values = values[n:]
+
+		c := chunkenc.NewXORChunk()
+		app, err := c.Appender()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range batch {
+			app.Append(int64(v.Timestamp), float64(v.Value))
+		}
+		chunks = append(chunks, Chunk{
+			MinTime: batch[0].Timestamp,
+			MaxTime: batch[n-1].Timestamp,
+			Data:    c.Bytes(),
+		})
+	}
+	return chunks, nil
+}
@@ -0,0 +1,63 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package storages defines the interface that storage backends (ClickHouse, etc.) implement.
+package storages
+
+import (
+	"context"
+
+	"github.com/prometheus/common/model"
+)
+
+// MatchType is an enumeration of label matching types copied from Prometheus' remote protocol.
+type MatchType int
+
+// Possible MatchType values.
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+// Matcher models a single label matcher.
+type Matcher struct {
+	Type  MatchType
+	Name  model.LabelName
+	Value string
+}
+
+// Query represents a single remote read query.
+type Query struct {
+	Start    model.Time
+	End      model.Time
+	Matchers []Matcher
+}
+
+// Storage is implemented by all supported storage backends.
+type Storage interface {
+	Read(ctx context.Context, queries []Query) ([]model.Matrix, error)
+	Write(ctx context.Context, data model.Matrix) error
+
+	// ReadStream is like Read but streams results incrementally, series by series, instead of
+	// materializing the whole response in memory. It backs STREAMED_XOR_CHUNKS remote read
+	// responses. Each emitted SeriesChunk.QueryIndex identifies which element of queries it
+	// answers, so results from several queries may be interleaved on the channel. The channel
+	// is closed once every matching series has been sent or ctx is canceled, whichever comes
+	// first.
+	ReadStream(ctx context.Context, queries []Query) (<-chan SeriesChunk, error)
+}
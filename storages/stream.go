@@ -0,0 +1,38 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package storages
+
+import "github.com/prometheus/common/model"
+
+// Chunk is a single XOR-encoded run of samples for a series, as produced by ChunksFromSamples.
+type Chunk struct {
+	MinTime model.Time
+	MaxTime model.Time
+	Data    []byte
+}
+
+// SeriesChunk is one element streamed by Storage.ReadStream: the label set of a single series
+// together with a batch of its chunks. QueryIndex is the index, within the queries slice passed
+// to ReadStream, of the query this series answers, so a caller fanning out several queries in
+// one call can tell which is which. Err is set, and the other fields left unset, if producing
+// this element failed; a non-nil Err always ends the stream.
+type SeriesChunk struct {
+	QueryIndex int
+	Labels     model.Metric
+	Chunks     []Chunk
+	Err        error
+}
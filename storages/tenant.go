@@ -0,0 +1,41 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package storages
+
+import (
+	"context"
+
+	"github.com/prometheus/common/model"
+)
+
+// TenantLabel is the label injected into every series on write, and required as a matcher on read,
+// so that a single storage backend can be safely shared between tenants.
+const TenantLabel model.LabelName = "tenant_id"
+
+type tenantContextKey struct{}
+
+// NewContext returns a copy of ctx carrying tenantID. Backends use FromContext to recover it
+// when deciding which partition/index to read from or write to.
+func NewContext(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+// FromContext returns the tenant ID stored in ctx by NewContext, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantContextKey{}).(string)
+	return tenantID, ok
+}
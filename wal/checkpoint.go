@@ -0,0 +1,79 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const checkpointFileName = "checkpoint.json"
+
+// checkpoint tracks the last WAL segment and offset the tailer has successfully handed off to
+// the shard pool, persisted to disk so a restart seeks straight to that offset and resumes
+// tailing from there instead of re-shipping already-delivered records.
+type checkpoint struct {
+	path string
+
+	mtx     sync.Mutex
+	segment int
+	offset  int64
+}
+
+type checkpointState struct {
+	Segment int   `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+func openCheckpoint(dir string) (*checkpoint, error) {
+	cp := &checkpoint{path: filepath.Join(dir, checkpointFileName)}
+
+	b, err := ioutil.ReadFile(cp.path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state checkpointState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+	cp.segment, cp.offset = state.Segment, state.Offset
+	return cp, nil
+}
+
+func (cp *checkpoint) last() (int, int64) {
+	cp.mtx.Lock()
+	defer cp.mtx.Unlock()
+	return cp.segment, cp.offset
+}
+
+func (cp *checkpoint) save(segment int, offset int64) error {
+	cp.mtx.Lock()
+	cp.segment, cp.offset = segment, offset
+	b, err := json.Marshal(checkpointState{Segment: segment, Offset: offset})
+	cp.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cp.path, b, 0o644)
+}
@@ -0,0 +1,63 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+// Package wal provides a durable write-ahead log in front of storages.Storage.Write: remote
+// write requests are appended to segmented on-disk files and acknowledged immediately, while a
+// background tailer ships batches to the storage backend, mirroring the tail-the-WAL design
+// Prometheus itself uses for remote_write.
+package wal
+
+import "time"
+
+// Config controls the WAL's on-disk layout, its delivery tailer, and the back-pressure Append
+// applies once the backlog of unflushed samples grows too large.
+type Config struct {
+	// Dir is the directory WAL segments and the checkpoint file are written to.
+	Dir string
+
+	// SegmentSize is the target size, in bytes, of each WAL segment file.
+	SegmentSize int
+
+	// QueueCapacity bounds how many samples may be accepted by Append but not yet confirmed
+	// written to storage before Append starts blocking.
+	QueueCapacity int
+
+	// EnqueueTimeout is how long Append blocks once QueueCapacity is reached before giving up
+	// with ErrQueueFull.
+	EnqueueTimeout time.Duration
+
+	// Shards is the initial number of parallel shards delivering batches to storage.
+	Shards int
+
+	// MaxShards bounds how high the EWMA-based reshard logic may grow Shards.
+	MaxShards int
+
+	// TailInterval is how often the tailer polls the WAL's tail for newly appended records.
+	TailInterval time.Duration
+}
+
+// DefaultConfig returns sane defaults for running a single-node ClickHouse-backed setup.
+func DefaultConfig(dir string) Config {
+	return Config{
+		Dir:            dir,
+		SegmentSize:    128 * 1024 * 1024,
+		QueueCapacity:  100000,
+		EnqueueTimeout: 5 * time.Second,
+		Shards:         1,
+		MaxShards:      10,
+		TailInterval:   100 * time.Millisecond,
+	}
+}
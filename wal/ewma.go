@@ -0,0 +1,68 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wal
+
+import (
+	"sync"
+	"time"
+)
+
+// ewmaRate tracks an exponentially weighted moving average of a counter sampled once per tick,
+// the same technique Prometheus' own remote_write queue manager uses to smooth out bursty
+// throughput before deciding whether to reshard.
+type ewmaRate struct {
+	alpha    float64
+	interval time.Duration
+
+	mtx    sync.Mutex
+	events int64
+	rate   float64
+	init   bool
+}
+
+func newEWMARate(alpha float64, interval time.Duration) *ewmaRate {
+	return &ewmaRate{alpha: alpha, interval: interval}
+}
+
+// incr records delta additional events since the last tick.
+func (r *ewmaRate) incr(delta int64) {
+	r.mtx.Lock()
+	r.events += delta
+	r.mtx.Unlock()
+}
+
+// tick folds the events counted since the last tick into the moving average. Call it once per
+// r.interval.
+func (r *ewmaRate) tick() {
+	r.mtx.Lock()
+	instant := float64(r.events) / r.interval.Seconds()
+	r.events = 0
+	if r.init {
+		r.rate += r.alpha * (instant - r.rate)
+	} else {
+		r.rate = instant
+		r.init = true
+	}
+	r.mtx.Unlock()
+}
+
+// value returns the current average rate, in events per second.
+func (r *ewmaRate) value() float64 {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.rate
+}
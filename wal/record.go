@@ -0,0 +1,44 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wal
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/prometheus/common/model"
+)
+
+// encodeRecord serializes data into a WAL record payload. We gob-encode the already-decoded
+// model.Matrix rather than reimplementing TSDB's columnar series/sample record format: this WAL
+// only needs to survive a process restart and be replayed once, not support random access or
+// compaction, so the simpler encoding is enough.
+func encodeRecord(data model.Matrix) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeRecord(b []byte) (model.Matrix, error) {
+	var data model.Matrix
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
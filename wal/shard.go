@@ -0,0 +1,159 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wal
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Percona-Lab/PromHouse/storages"
+)
+
+// writeTimeout bounds a single shard's call to Storage.Write before it is considered failed and
+// retried.
+const writeTimeout = 30 * time.Second
+
+// shardPool delivers batches read off the WAL to storage using a configurable number of
+// parallel shards, growing that number based on an EWMA of recent throughput -- the same
+// signal Prometheus' remote_write queue manager uses to decide a backend can't keep up.
+// Reshard decisions are grow-only: once started, a shard keeps running for the life of the
+// pool rather than being retired when load drops.
+type shardPool struct {
+	cfg     Config
+	storage storages.Storage
+	logger  *logrus.Entry
+	pending *int64 // shared with WAL.Append; decremented once a batch is durably written
+
+	batches chan model.Matrix
+
+	incomingRate *ewmaRate
+	outgoingRate *ewmaRate
+
+	numShards int32
+	wg        sync.WaitGroup
+	stopc     chan struct{}
+}
+
+func newShardPool(cfg Config, storage storages.Storage, logger *logrus.Entry, pending *int64) *shardPool {
+	sp := &shardPool{
+		cfg:          cfg,
+		storage:      storage,
+		logger:       logger,
+		pending:      pending,
+		batches:      make(chan model.Matrix, cfg.QueueCapacity),
+		incomingRate: newEWMARate(0.2, cfg.TailInterval),
+		outgoingRate: newEWMARate(0.2, cfg.TailInterval),
+		stopc:        make(chan struct{}),
+	}
+	for i := 0; i < cfg.Shards; i++ {
+		sp.startShard()
+	}
+	go sp.reshardLoop()
+	return sp
+}
+
+func (sp *shardPool) startShard() {
+	atomic.AddInt32(&sp.numShards, 1)
+	sp.wg.Add(1)
+	go func() {
+		defer sp.wg.Done()
+		for {
+			select {
+			case <-sp.stopc:
+				return
+			case batch, ok := <-sp.batches:
+				if !ok {
+					return
+				}
+				sp.deliver(batch)
+			}
+		}
+	}()
+}
+
+// enqueue hands a batch read off the WAL to the shard pool for delivery, recording it against
+// the incoming-rate EWMA used by reshardLoop.
+func (sp *shardPool) enqueue(batch model.Matrix) {
+	sp.incomingRate.incr(int64(len(batch)))
+	sp.batches <- batch
+}
+
+// deliver writes batch to storage, retrying indefinitely on failure: the WAL already durably
+// holds the data, so a slow or momentarily unavailable backend only delays delivery rather than
+// losing samples. The retry wait is interruptible via sp.stopc, so a shard blocked on a down
+// backend doesn't make shardPool.stop's wg.Wait (and therefore WAL.Close) hang forever; on
+// shutdown the in-flight batch is abandoned undelivered, since the WAL itself -- not this
+// in-memory batch -- is the durable copy and will be re-tailed from the checkpoint on restart.
+func (sp *shardPool) deliver(batch model.Matrix) {
+	var samples int64
+	for _, ss := range batch {
+		samples += int64(len(ss.Values))
+	}
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		err := sp.storage.Write(ctx, batch)
+		cancel()
+		if err == nil {
+			break
+		}
+		sp.logger.Warnf("WAL tailer: retrying failed write of %d samples: %s", samples, err)
+		select {
+		case <-time.After(time.Second):
+		case <-sp.stopc:
+			sp.logger.Warnf("WAL tailer: shutting down with %d samples still undelivered; will re-tail from checkpoint", samples)
+			return
+		}
+	}
+
+	atomic.AddInt64(sp.pending, -samples)
+	sp.outgoingRate.incr(int64(len(batch)))
+}
+
+// reshardLoop periodically compares incoming vs outgoing throughput and grows the number of
+// active shards, within [cfg.Shards, cfg.MaxShards], when the backend can't keep up.
+func (sp *shardPool) reshardLoop() {
+	ticker := time.NewTicker(sp.cfg.TailInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sp.stopc:
+			return
+		case <-ticker.C:
+			sp.incomingRate.tick()
+			sp.outgoingRate.tick()
+
+			in, out := sp.incomingRate.value(), sp.outgoingRate.value()
+			current := int(atomic.LoadInt32(&sp.numShards))
+			if out > 0 && in > out*1.1 && current < sp.cfg.MaxShards {
+				sp.logger.Infof("WAL tailer: resharding %d -> %d (in=%.1f/s out=%.1f/s)", current, current+1, in, out)
+				sp.startShard()
+			}
+		}
+	}
+}
+
+func (sp *shardPool) stop() {
+	close(sp.stopc)
+	close(sp.batches)
+	sp.wg.Wait()
+}
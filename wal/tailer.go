@@ -0,0 +1,138 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wal
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	tsdbwal "github.com/prometheus/prometheus/tsdb/wal"
+	"github.com/sirupsen/logrus"
+)
+
+// tailer polls the WAL's current segment on a ticker using tsdb/wal.LiveReader, so it picks up
+// records as they are appended rather than waiting for a segment to be sealed, and hands each
+// decoded record to the shard pool for delivery to storage. It is the sole path from "logged to
+// the WAL" to "enqueued for delivery", so Append can return as soon as its record is written without
+// knowing anything about shards or storage availability.
+type tailer struct {
+	cfg    Config
+	w      *tsdbwal.WAL
+	logger *logrus.Entry
+	shards *shardPool
+	cp     *checkpoint
+	done   <-chan struct{}
+
+	pending *int64
+
+	// stopped is closed once run returns, so Close can wait for the tailer to be fully done
+	// delivering before the shard pool's batch channel is torn down.
+	stopped chan struct{}
+}
+
+func newTailer(cfg Config, w *tsdbwal.WAL, logger *logrus.Entry, shards *shardPool, cp *checkpoint, pending *int64, done <-chan struct{}) *tailer {
+	return &tailer{cfg: cfg, w: w, logger: logger, shards: shards, cp: cp, pending: pending, done: done, stopped: make(chan struct{})}
+}
+
+func (t *tailer) run() {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(t.cfg.TailInterval)
+	defer ticker.Stop()
+
+	// Resume tailing from the checkpointed offset: seek the segment before wrapping it in a
+	// LiveReader so records already shipped to storage before a restart aren't re-delivered.
+	// LiveReader.Offset() counts bytes read from wherever the reader was created, not from the
+	// start of the segment file, so base tracks the absolute position the reader was seeked to
+	// and every checkpoint persists base+reader.Offset() rather than reader.Offset() alone.
+	segment, offset := t.cp.last()
+	base := offset
+	reader, err := t.openSegment(segment, offset)
+	if err != nil {
+		t.logger.Errorf("WAL tailer: failed to open segment %d: %s", segment, err)
+	}
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			if reader == nil {
+				base = offset
+				reader, err = t.openSegment(segment, offset)
+				if err != nil {
+					continue // segment not created yet; retry next tick
+				}
+			}
+
+			// Look for a newer segment before draining, so that a writer moving on to it after
+			// the drain below can't leave records in this segment that were never read.
+			_, last, segErr := t.w.Segments()
+
+			for reader.Next() {
+				data, err := decodeRecord(reader.Record())
+				if err != nil {
+					t.logger.Errorf("WAL tailer: failed to decode record: %s", err)
+					continue
+				}
+
+				var samples int64
+				for _, ss := range data {
+					samples += int64(len(ss.Values))
+				}
+				atomic.AddInt64(t.pending, samples)
+
+				t.shards.enqueue(data)
+				offset = base + reader.Offset()
+			}
+			if err := reader.Err(); err != nil && err != io.EOF {
+				t.logger.Errorf("WAL tailer: live reader error: %s", err)
+			}
+
+			if err := t.cp.save(segment, offset); err != nil {
+				t.logger.Errorf("WAL tailer: failed to save checkpoint: %s", err)
+			}
+
+			// segment is only sealed (no longer written to) once a newer one exists; only
+			// if one already existed before the drain above is reader.Next() returning false
+			// a sign it is fully drained, and only then do we move on -- one segment at a time,
+			// so a writer that advances several segments within a single tick can't make us
+			// skip any of them.
+			if segErr == nil && last > segment {
+				segment++
+				offset = 0
+				base = 0
+				reader = nil
+			}
+		}
+	}
+}
+
+func (t *tailer) openSegment(segment int, offset int64) (*tsdbwal.LiveReader, error) {
+	sr, err := tsdbwal.OpenReadSegment(tsdbwal.SegmentName(t.w.Dir(), segment))
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := sr.Seek(offset, io.SeekStart); err != nil {
+			sr.Close()
+			return nil, err
+		}
+	}
+	return tsdbwal.NewLiveReader(nil, nil, sr), nil
+}
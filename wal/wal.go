@@ -0,0 +1,119 @@
+// PromHouse
+// Copyright (C) 2017 Percona LLC
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published
+// by the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <http://www.gnu.org/licenses/>.
+
+package wal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/common/model"
+	tsdbwal "github.com/prometheus/prometheus/tsdb/wal"
+	"github.com/sirupsen/logrus"
+
+	"github.com/Percona-Lab/PromHouse/storages"
+)
+
+// ErrQueueFull is returned by Append when the backlog of samples accepted but not yet durably
+// written to storage is still at capacity after waiting up to Config.EnqueueTimeout. Callers
+// should translate it to an HTTP 429 or 503 so the remote-write client backs off and retries.
+var ErrQueueFull = errors.New("wal: queue is full, try again later")
+
+// pollInterval is how often Append re-checks the backlog while waiting for it to drain.
+const pollInterval = 10 * time.Millisecond
+
+// WAL durably buffers remote-write requests ahead of a storages.Storage: Append writes each
+// request to a local on-disk segment and returns immediately, while a background tailer reads
+// newly appended records and ships them to storage, retrying until it succeeds. This prevents
+// sample loss when the storage backend is briefly unavailable or slow, mirroring the
+// tail-the-WAL design Prometheus itself uses for remote_write.
+type WAL struct {
+	cfg    Config
+	logger *logrus.Entry
+	w      *tsdbwal.WAL
+
+	pending int64 // samples accepted by Append but not yet confirmed written to storage
+	shards  *shardPool
+	tailer  *tailer
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Open creates or reopens a WAL rooted at cfg.Dir and starts its background tailer shipping
+// batches to storage.
+func Open(cfg Config, storage storages.Storage, logger *logrus.Entry) (*WAL, error) {
+	w, err := tsdbwal.NewSize(nil, nil, cfg.Dir, cfg.SegmentSize)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := openCheckpoint(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	wl := &WAL{
+		cfg:    cfg,
+		logger: logger,
+		w:      w,
+		done:   make(chan struct{}),
+	}
+	wl.shards = newShardPool(cfg, storage, logger, &wl.pending)
+	wl.tailer = newTailer(cfg, w, logger, wl.shards, cp, &wl.pending, wl.done)
+	go wl.tailer.run()
+
+	return wl, nil
+}
+
+// Append writes data as a record to the WAL's current segment and returns once the write is
+// done; it does not wait for an fsync of the segment file. It blocks, up
+// to cfg.EnqueueTimeout, while the backlog of samples not yet confirmed written to storage is
+// at cfg.QueueCapacity, and gives up with ErrQueueFull if the backlog never drains in time --
+// the back-pressure signal PromAPI.Write uses to answer an overloaded writer with a 429/503
+// instead of silently letting the backlog grow without bound.
+func (wl *WAL) Append(ctx context.Context, data model.Matrix) error {
+	deadline := time.Now().Add(wl.cfg.EnqueueTimeout)
+	for atomic.LoadInt64(&wl.pending) >= int64(wl.cfg.QueueCapacity) {
+		if time.Now().After(deadline) {
+			return ErrQueueFull
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+
+	b, err := encodeRecord(data)
+	if err != nil {
+		return err
+	}
+	return wl.w.Log(b)
+}
+
+// Close stops the background tailer, stops the shard pool, and closes the underlying WAL.
+// Batches still queued in the shard pool, or still being retried, are not written to storage.
+func (wl *WAL) Close() error {
+	wl.closeOnce.Do(func() {
+		close(wl.done)
+		<-wl.tailer.stopped // wait for the tailer to stop enqueueing before batches is closed
+		wl.shards.stop()
+	})
+	return wl.w.Close()
+}